@@ -0,0 +1,223 @@
+package nap
+
+import (
+	"database/sql"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancer selects which of nap's slaves to route a read query to. pdbs and
+// healthy are parallel slices describing the configured slaves only (the
+// master is never passed to a Balancer); healthy[i] reports whether the
+// most recent health check against pdbs[i] succeeded. healthy is nil when
+// no health check has been configured, in which case every slave should be
+// treated as healthy. Pick must return an index into pdbs; an out of range
+// index is treated as 0.
+type Balancer interface {
+	Pick(pdbs []*sql.DB, healthy []bool) int
+}
+
+// LatencyReporter is implemented by balancers that want to observe the
+// latency of queries they routed, such as P2CLatencyBalancer. DB reports
+// latency, keyed by the same slave index passed to Pick, after every read
+// query it sends to a slave.
+type LatencyReporter interface {
+	Report(idx int, d time.Duration)
+}
+
+// isHealthy reports whether pdbs[idx] should be considered a candidate,
+// treating a nil healthy slice (no health check configured) as all-healthy.
+func isHealthy(healthy []bool, idx int) bool {
+	return healthy == nil || idx >= len(healthy) || healthy[idx]
+}
+
+// RoundRobinBalancer cycles through the healthy slaves in order, matching
+// nap's original slave-selection behavior. The zero value is ready to use.
+type RoundRobinBalancer struct {
+	count uint64
+}
+
+// Pick implements Balancer.
+func (b *RoundRobinBalancer) Pick(pdbs []*sql.DB, healthy []bool) int {
+	n := len(pdbs)
+	if n == 0 {
+		return 0
+	}
+
+	start := int(atomic.AddUint64(&b.count, 1) % uint64(n))
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		if isHealthy(healthy, idx) {
+			return idx
+		}
+	}
+
+	return start
+}
+
+// RandomBalancer picks a uniformly random healthy slave on every call. The
+// zero value is ready to use.
+type RandomBalancer struct{}
+
+// Pick implements Balancer.
+func (RandomBalancer) Pick(pdbs []*sql.DB, healthy []bool) int {
+	n := len(pdbs)
+	if n == 0 {
+		return 0
+	}
+
+	candidates := 0
+	for i := 0; i < n; i++ {
+		if isHealthy(healthy, i) {
+			candidates++
+		}
+	}
+	if candidates == 0 {
+		return rand.Intn(n)
+	}
+
+	k := rand.Intn(candidates)
+	for i := 0; i < n; i++ {
+		if isHealthy(healthy, i) {
+			if k == 0 {
+				return i
+			}
+			k--
+		}
+	}
+
+	return 0
+}
+
+// WeightedBalancer picks a slave with probability proportional to its
+// weight, skipping any slave currently marked unhealthy. weights must have
+// the same length and order as the slaves passed to Open/OpenDB; a slave
+// with weight 0 is never picked while a healthier alternative exists.
+func WeightedBalancer(weights []int) Balancer {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	return &weightedBalancer{weights: weights, total: total}
+}
+
+type weightedBalancer struct {
+	weights []int
+	total   int
+}
+
+// Pick implements Balancer.
+func (b *weightedBalancer) Pick(pdbs []*sql.DB, healthy []bool) int {
+	n := len(pdbs)
+	if n == 0 {
+		return 0
+	}
+
+	if b.total <= 0 || len(b.weights) != n {
+		return RandomBalancer{}.Pick(pdbs, healthy)
+	}
+
+	total := 0
+	for i, w := range b.weights {
+		if isHealthy(healthy, i) {
+			total += w
+		}
+	}
+	if total <= 0 {
+		return RandomBalancer{}.Pick(pdbs, healthy)
+	}
+
+	r := rand.Intn(total)
+	for i := 0; i < n; i++ {
+		if !isHealthy(healthy, i) {
+			continue
+		}
+		r -= b.weights[i]
+		if r < 0 {
+			return i
+		}
+	}
+
+	return RandomBalancer{}.Pick(pdbs, healthy)
+}
+
+// p2cEWMA is the smoothing factor applied to each new latency sample; higher
+// values react to recent samples faster.
+const p2cEWMA = 0.3
+
+// P2CLatencyBalancer draws two distinct uniformly random slaves and picks
+// the faster of the two (power of two choices), using an exponentially
+// weighted moving average of each slave's observed query latency as its
+// cost estimate. Health is applied after the draw: if only one of the two
+// is healthy it is picked without comparing latencies, and if neither is,
+// the lowest-indexed healthy slave is picked. It
+// implements LatencyReporter so DB can feed it per-query timings. The zero
+// value is ready to use.
+type P2CLatencyBalancer struct {
+	mu      sync.Mutex
+	latency map[int]time.Duration
+}
+
+// Pick implements Balancer.
+func (b *P2CLatencyBalancer) Pick(pdbs []*sql.DB, healthy []bool) int {
+	n := len(pdbs)
+	if n == 0 {
+		return 0
+	}
+	if n == 1 {
+		return 0
+	}
+
+	i, j := rand.Intn(n), rand.Intn(n-1)
+	if j >= i {
+		j++
+	}
+
+	switch {
+	case !isHealthy(healthy, i) && isHealthy(healthy, j):
+		return j
+	case isHealthy(healthy, i) && !isHealthy(healthy, j):
+		return i
+	case !isHealthy(healthy, i) && !isHealthy(healthy, j):
+		// Neither of the two draws is healthy; fall back to scanning the
+		// full candidate set for a healthy index, the same way the other
+		// Balancers do, instead of returning an unhealthy one anyway.
+		for k := 0; k < n; k++ {
+			if isHealthy(healthy, k) {
+				return k
+			}
+		}
+		return i
+	case b.latencyOf(j) < b.latencyOf(i):
+		return j
+	default:
+		return i
+	}
+}
+
+// Report implements LatencyReporter.
+func (b *P2CLatencyBalancer) Report(idx int, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.latency == nil {
+		b.latency = make(map[int]time.Duration)
+	}
+
+	prev, ok := b.latency[idx]
+	if !ok {
+		b.latency[idx] = d
+		return
+	}
+
+	b.latency[idx] = time.Duration(p2cEWMA*float64(d) + (1-p2cEWMA)*float64(prev))
+}
+
+func (b *P2CLatencyBalancer) latencyOf(idx int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.latency[idx]
+}
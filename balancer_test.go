@@ -0,0 +1,92 @@
+package nap
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinBalancerSkipsUnhealthy(t *testing.T) {
+	pdbs := make([]*sql.DB, 3)
+	healthy := []bool{true, false, true}
+
+	b := &RoundRobinBalancer{}
+	for i := 0; i < 10; i++ {
+		if idx := b.Pick(pdbs, healthy); !healthy[idx] {
+			t.Fatalf("Pick returned unhealthy index %d", idx)
+		}
+	}
+}
+
+func TestWeightedBalancerRespectsWeights(t *testing.T) {
+	pdbs := make([]*sql.DB, 2)
+	b := WeightedBalancer([]int{1, 0})
+
+	for i := 0; i < 20; i++ {
+		if idx := b.Pick(pdbs, nil); idx != 0 {
+			t.Fatalf("Expected WeightedBalancer to always pick index 0, got %d", idx)
+		}
+	}
+}
+
+func TestP2CLatencyBalancerPrefersFaster(t *testing.T) {
+	pdbs := make([]*sql.DB, 2)
+	b := &P2CLatencyBalancer{}
+
+	b.Report(0, 50*time.Millisecond)
+	b.Report(1, 5*time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		if idx := b.Pick(pdbs, nil); idx != 1 {
+			t.Fatalf("Expected P2CLatencyBalancer to prefer the faster replica, got %d", idx)
+		}
+	}
+}
+
+func TestP2CLatencyBalancerSkipsUnhealthy(t *testing.T) {
+	pdbs := make([]*sql.DB, 3)
+	healthy := []bool{true, false, false}
+
+	b := &P2CLatencyBalancer{}
+	for i := 0; i < 50; i++ {
+		if idx := b.Pick(pdbs, healthy); !healthy[idx] {
+			t.Fatalf("Pick returned unhealthy index %d", idx)
+		}
+	}
+}
+
+func TestStmtQueryReportsLatency(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	b := &P2CLatencyBalancer{}
+	db.SetBalancer(b)
+
+	st, err := db.Prepare("select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// Prepared statements are the idiomatic, performance-sensitive read path;
+	// if Stmt.Query didn't report back, b.latency would stay empty forever
+	// and every later Pick would tie, degrading P2C to "always return i".
+	for i := 0; i < 20; i++ {
+		rows, err := st.Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	b.mu.Lock()
+	reported := len(b.latency)
+	b.mu.Unlock()
+
+	if reported == 0 {
+		t.Fatal("expected Stmt.Query to report latency back to the Balancer, got none")
+	}
+}
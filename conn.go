@@ -0,0 +1,108 @@
+package nap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// connOptions configures which physical database Conn reserves a
+// connection from.
+type connOptions struct {
+	pdbIndex    int  // index into DB.pdbs; defaults to the master (0)
+	fromReplica bool // set by ConnFromReplica
+}
+
+// ConnOption configures DB.Conn. See ConnFromReplica.
+type ConnOption func(*connOptions)
+
+// ConnFromReplica reserves the connection from the slave at idx (0 is the
+// first slave configured), instead of the master.
+func ConnFromReplica(idx int) ConnOption {
+	return func(o *connOptions) {
+		o.pdbIndex, o.fromReplica = idx+1, true
+	}
+}
+
+// Conn is a single reserved connection to one of nap's physical databases,
+// mirroring database/sql.Conn for session-scoped work that requires a
+// consistent underlying connection across calls, such as temp tables,
+// SET LOCAL, or advisory locks.
+type Conn struct {
+	conn *sql.Conn
+}
+
+// Conn reserves a single connection from the master, or from a specific
+// slave if a ConnFromReplica option is given. The caller must Close the
+// returned Conn to release the connection back to its physical database's
+// pool. Conn returns an error if a ConnFromReplica index has no matching
+// slave, rather than silently falling back to the master: the caller asked
+// to keep this session-scoped work off the master, so a typo'd index must
+// not defeat that.
+func (db *DB) Conn(ctx context.Context, opts ...ConnOption) (*Conn, error) {
+	var o connOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.pdbIndex < 0 || o.pdbIndex >= len(db.pdbs) || (o.fromReplica && o.pdbIndex == 0) {
+		return nil, fmt.Errorf("nap: no slave at index %d (have %d slaves configured)", o.pdbIndex-1, len(db.pdbs)-1)
+	}
+
+	c, err := db.pdbs[o.pdbIndex].Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{conn: c}, nil
+}
+
+// MasterConn reserves a single connection from the master. It is
+// equivalent to Conn(ctx) with no options.
+func (db *DB) MasterConn(ctx context.Context) (*Conn, error) {
+	return db.Conn(ctx)
+}
+
+// SlaveConn reserves a single connection from the slave at idx (0 is the
+// first slave configured). Unlike Query/Exec, the slave is chosen
+// explicitly rather than through the configured Balancer, since the
+// connection is pinned for the caller's exclusive use for its lifetime.
+func (db *DB) SlaveConn(ctx context.Context, idx int) (*Conn, error) {
+	return db.Conn(ctx, ConnFromReplica(idx))
+}
+
+// Close releases the underlying connection back to its physical database's
+// pool.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// BeginTx starts a transaction on the reserved connection.
+func (c *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
+
+// ExecContext executes a query without returning any rows on the reserved
+// connection.
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, query, args...)
+}
+
+// QueryContext executes a query that returns rows, typically a SELECT, on
+// the reserved connection.
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return at most one
+// row on the reserved connection. Errors are deferred until Row's Scan
+// method is called.
+func (c *Conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(ctx, query, args...)
+}
+
+// PrepareContext creates a prepared statement for later queries or
+// executions on the reserved connection.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(ctx, query)
+}
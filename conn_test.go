@@ -0,0 +1,60 @@
+package nap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConn(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	conn, err := db.MasterConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "create temp table t1 (c1 int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The temp table only exists on the connection that created it,
+	// proving every call above was pinned to the same reserved connection.
+	rows, err := conn.QueryContext(ctx, "select * from t1")
+	if err != nil {
+		t.Fatalf("Expected reserved connection to see its own temp table. Got: %s", err)
+	}
+	rows.Close()
+}
+
+func TestSlaveConn(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	conn, err := db.SlaveConn(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+}
+
+func TestSlaveConnOutOfRange(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.SlaveConn(context.Background(), 99); err == nil {
+		t.Error("expected SlaveConn with an out-of-range index to return an error, not fall back to the master")
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 )
 
@@ -13,8 +13,16 @@ import (
 // forming a single master multiple slaves topology.
 // Reads and writes are automatically directed to the correct physical db.
 type DB struct {
-	pdbs  []*sql.DB // Physical databases
-	count uint64    // Monotonically incrementing counter on each query
+	pdbs []*sql.DB // Physical databases
+
+	balancer Balancer
+
+	mu                   sync.RWMutex
+	healthy              []bool // Parallel to pdbs[1:]; nil until SetHealthCheck is called
+	healthCheckHook      func(idx int, err error)
+	stopHealthCheck      chan struct{}
+	healthCheckWG        sync.WaitGroup
+	readYourWritesWindow time.Duration
 }
 
 // Open concurrently opens each underlying physical db.
@@ -22,7 +30,7 @@ type DB struct {
 // one being used as the master and the rest as slaves.
 func Open(driverName, dataSourceNames string) (*DB, error) {
 	conns := strings.Split(dataSourceNames, ";")
-	db := &DB{pdbs: make([]*sql.DB, len(conns))}
+	db := newDB(make([]*sql.DB, len(conns)))
 
 	err := scatter(len(db.pdbs), func(i int) (err error) {
 		db.pdbs[i], err = sql.Open(driverName, conns[i])
@@ -36,8 +44,49 @@ func Open(driverName, dataSourceNames string) (*DB, error) {
 	return db, nil
 }
 
-// Close closes all physical databases concurrently, releasing any open resources.
+// OpenDB opens a logical database around a master driver.Connector and zero
+// or more slave driver.Connectors, mirroring the standard library's
+// sql.OpenDB. Unlike Open, it doesn't require a driver to be registered and
+// lets callers supply connectors that carry per-replica auth callbacks, TLS
+// configs or custom dialers, such as those used by cloudsql-proxy or RDS IAM
+// auth.
+func OpenDB(master driver.Connector, slaves ...driver.Connector) *DB {
+	pdbs := make([]*sql.DB, 1+len(slaves))
+	pdbs[0] = sql.OpenDB(master)
+
+	for i, slave := range slaves {
+		pdbs[i+1] = sql.OpenDB(slave)
+	}
+
+	return newDB(pdbs)
+}
+
+// OpenDBs wraps pre-built physical databases into a single logical DB, with
+// the first one acting as the master and the rest as slaves. dbs must have
+// at least one entry; OpenDBs panics otherwise, since a *DB with no master
+// can't serve Master, Begin or any write without indexing past pdbs[0].
+func OpenDBs(dbs []*sql.DB) *DB {
+	if len(dbs) == 0 {
+		panic("nap: OpenDBs requires at least one *sql.DB to act as the master")
+	}
+
+	pdbs := make([]*sql.DB, len(dbs))
+	copy(pdbs, dbs)
+	return newDB(pdbs)
+}
+
+// newDB wraps pdbs with nap's default configuration: a RoundRobinBalancer
+// and no health checking.
+func newDB(pdbs []*sql.DB) *DB {
+	return &DB{pdbs: pdbs, balancer: &RoundRobinBalancer{}}
+}
+
+// Close closes all physical databases concurrently, releasing any open
+// resources, and stops the background health check goroutine if one was
+// started via SetHealthCheck.
 func (db *DB) Close() error {
+	db.stopHealthCheckLocked()
+
 	return scatter(len(db.pdbs), func(i int) error {
 		return db.pdbs[i].Close()
 	})
@@ -59,7 +108,11 @@ func (db *DB) Begin() (*sql.Tx, error) {
 // If a non-default isolation level is used that the driver doesn't support,
 // an error will be returned.
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	return db.Master().BeginTx(ctx, opts)
+	tx, err := db.Master().BeginTx(ctx, opts)
+	if err == nil {
+		db.recordWrite(ctx)
+	}
+	return tx, err
 }
 
 // Exec executes a query without returning any rows.
@@ -70,10 +123,19 @@ func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
 }
 
 // ExecContext executes a query without returning any rows.
-// The args are for any placeholder parameters in the query.
-// Exec uses the master as the underlying physical db.
+// The args are for any placeholder parameters in the query, which may
+// include a nap routing hint such as UseReplica.
+// ExecContext uses the master as the underlying physical db by default. If
+// ctx carries a nap Session, this Exec is recorded against it so that
+// subsequent reads within the DB.SetReadYourWritesWindow are routed to the
+// master.
 func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return db.Master().ExecContext(ctx, query, args...)
+	args, r := extractHints(args)
+	res, err := db.route(r, db.Master).ExecContext(ctx, query, args...)
+	if err == nil {
+		db.recordWrite(ctx)
+	}
+	return res, err
 }
 
 // Ping verifies if a connection to each physical database is still alive,
@@ -92,6 +154,42 @@ func (db *DB) PingContext(ctx context.Context) error {
 	})
 }
 
+// Stats returns the sql.DBStats of each physical database, with index 0
+// always being the master and the rest the slaves in the order they were
+// configured.
+func (db *DB) Stats() []sql.DBStats {
+	stats := make([]sql.DBStats, len(db.pdbs))
+	for i := range db.pdbs {
+		stats[i] = db.pdbs[i].Stats()
+	}
+	return stats
+}
+
+// AggregateStats sums the numeric counters, including WaitDuration, across
+// the sql.DBStats of every physical database, and takes the maximum of
+// MaxOpenConnections, providing a single sql.DBStats suitable for exporting
+// nap-managed pools as a single metric series.
+func (db *DB) AggregateStats() sql.DBStats {
+	var agg sql.DBStats
+
+	for _, s := range db.Stats() {
+		agg.OpenConnections += s.OpenConnections
+		agg.InUse += s.InUse
+		agg.Idle += s.Idle
+		agg.WaitCount += s.WaitCount
+		agg.WaitDuration += s.WaitDuration
+		agg.MaxIdleClosed += s.MaxIdleClosed
+		agg.MaxIdleTimeClosed += s.MaxIdleTimeClosed
+		agg.MaxLifetimeClosed += s.MaxLifetimeClosed
+
+		if s.MaxOpenConnections > agg.MaxOpenConnections {
+			agg.MaxOpenConnections = s.MaxOpenConnections
+		}
+	}
+
+	return agg
+}
+
 // Prepare creates a prepared statement for later queries or executions
 // on each physical database, concurrently.
 func (db *DB) Prepare(query string) (Stmt, error) {
@@ -132,14 +230,29 @@ func (db *DB) PrepareContext(ctx context.Context, query string) (Stmt, error) {
 // The args are for any placeholder parameters in the query.
 // Query uses a slave as the physical db.
 func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return db.Slave().Query(query, args...)
+	idx, pdb := db.pickSlave()
+	start := time.Now()
+	rows, err := pdb.Query(query, args...)
+	db.reportLatency(idx, time.Since(start))
+	return rows, err
 }
 
 // QueryContext executes a query that returns rows, typically a SELECT.
-// The args are for any placeholder parameters in the query.
-// QueryContext uses a slave as the physical db.
+// The args are for any placeholder parameters in the query, which may
+// include a nap routing hint such as UseMaster.
+// QueryContext uses a slave as the physical db by default, unless ctx
+// carries a nap Session with a write recorded within the
+// DB.SetReadYourWritesWindow, in which case it uses the master.
 func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	return db.Slave().QueryContext(ctx, query, args...)
+	args, r := extractHints(args)
+	if db.recentWrite(ctx) {
+		r.useMaster = true
+	}
+	idx, pdb := db.pickRead(r)
+	start := time.Now()
+	rows, err := pdb.QueryContext(ctx, query, args...)
+	db.reportLatency(idx, time.Since(start))
+	return rows, err
 }
 
 // QueryRow executes a query that is expected to return at most one row.
@@ -147,15 +260,31 @@ func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{
 // Errors are deferred until Row's Scan method is called.
 // QueryRow uses a slave as the physical db.
 func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
-	return db.Slave().QueryRow(query, args...)
+	idx, pdb := db.pickSlave()
+	start := time.Now()
+	row := pdb.QueryRow(query, args...)
+	db.reportLatency(idx, time.Since(start))
+	return row
 }
 
 // QueryRowContext executes a query that is expected to return at most one row.
 // QueryRowContext always return a non-nil value.
 // Errors are deferred until Row's Scan method is called.
-// QueryRowContext uses a slave as the physical db.
+// The args are for any placeholder parameters in the query, which may
+// include a nap routing hint such as UseMaster.
+// QueryRowContext uses a slave as the physical db by default, unless ctx
+// carries a nap Session with a write recorded within the
+// DB.SetReadYourWritesWindow, in which case it uses the master.
 func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	return db.Slave().QueryRowContext(ctx, query, args...)
+	args, r := extractHints(args)
+	if db.recentWrite(ctx) {
+		r.useMaster = true
+	}
+	idx, pdb := db.pickRead(r)
+	start := time.Now()
+	row := pdb.QueryRowContext(ctx, query, args...)
+	db.reportLatency(idx, time.Since(start))
+	return row
 }
 
 // SetMaxIdleConns sets the maximum number of connections in the idle
@@ -190,9 +319,11 @@ func (db *DB) SetConnMaxLifetime(d time.Duration) {
 	}
 }
 
-// Slave returns one of the physical databases which is a slave
+// Slave returns one of the physical databases which is a slave, chosen by
+// the configured Balancer.
 func (db *DB) Slave() *sql.DB {
-	return db.pdbs[db.slave(len(db.pdbs))]
+	_, pdb := db.pickSlave()
+	return pdb
 }
 
 // Master returns the master physical database
@@ -200,9 +331,186 @@ func (db *DB) Master() *sql.DB {
 	return db.pdbs[0]
 }
 
-func (db *DB) slave(n int) int {
-	if n <= 1 {
-		return 0
+// SetBalancer configures the Balancer used to pick a slave for reads. The
+// default is a RoundRobinBalancer, matching nap's original behavior.
+// SetBalancer is not safe to call concurrently with queries.
+func (db *DB) SetBalancer(b Balancer) {
+	db.balancer = b
+}
+
+// SetReadYourWritesWindow enables opt-in read-your-writes consistency: a
+// QueryContext or QueryRowContext made with a context derived from Session,
+// within d of the last ExecContext or BeginTx made with that same context,
+// is routed to the master instead of a slave. A Stmt prepared on db
+// honors the same window for its QueryContext and QueryRowContext. Pass 0,
+// the default, to disable it. Calls made without a Session context, or
+// through Exec/Query/QueryRow and their non-context counterparts, are
+// never affected since they carry no session to consult.
+func (db *DB) SetReadYourWritesWindow(d time.Duration) {
+	db.mu.Lock()
+	db.readYourWritesWindow = d
+	db.mu.Unlock()
+}
+
+// recordWrite records a write against ctx's nap Session, if any, for
+// DB.SetReadYourWritesWindow.
+func (db *DB) recordWrite(ctx context.Context) {
+	if s := sessionFrom(ctx); s != nil {
+		s.recordWrite()
+	}
+}
+
+// recentWrite reports whether ctx's nap Session recorded a write within the
+// configured read-your-writes window.
+func (db *DB) recentWrite(ctx context.Context) bool {
+	db.mu.RLock()
+	window := db.readYourWritesWindow
+	db.mu.RUnlock()
+
+	if window <= 0 {
+		return false
+	}
+
+	s := sessionFrom(ctx)
+	return s != nil && s.recentWrite(window)
+}
+
+// SetHealthCheck starts a background goroutine that PingContexts every
+// slave every interval, aborting each ping after timeout, and marks a slave
+// unhealthy on failure. The configured Balancer excludes unhealthy slaves
+// from its picks until they recover. Calling SetHealthCheck again replaces
+// the running health check; Close stops it.
+func (db *DB) SetHealthCheck(interval, timeout time.Duration) {
+	db.stopHealthCheckLocked()
+
+	n := len(db.pdbs) - 1
+	if n <= 0 {
+		return
+	}
+
+	healthy := make([]bool, n)
+	for i := range healthy {
+		healthy[i] = true
+	}
+
+	db.mu.Lock()
+	db.healthy = healthy
+	stop := make(chan struct{})
+	db.stopHealthCheck = stop
+	db.mu.Unlock()
+
+	db.healthCheckWG.Add(1)
+	go db.runHealthCheck(interval, timeout, stop)
+}
+
+// HealthCheckHook registers fn to be called with a slave's index (0-based,
+// among slaves) and the error of every health check ping sent to it, for
+// observability. Pass nil to stop receiving calls.
+func (db *DB) HealthCheckHook(fn func(idx int, err error)) {
+	db.mu.Lock()
+	db.healthCheckHook = fn
+	db.mu.Unlock()
+}
+
+func (db *DB) runHealthCheck(interval, timeout time.Duration, stop chan struct{}) {
+	defer db.healthCheckWG.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			db.checkHealth(timeout)
+		}
+	}
+}
+
+func (db *DB) checkHealth(timeout time.Duration) {
+	db.mu.RLock()
+	hook := db.healthCheckHook
+	db.mu.RUnlock()
+
+	for i, pdb := range db.pdbs[1:] {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := pdb.PingContext(ctx)
+		cancel()
+
+		db.mu.Lock()
+		if i < len(db.healthy) {
+			db.healthy[i] = err == nil
+		}
+		db.mu.Unlock()
+
+		if hook != nil {
+			hook(i, err)
+		}
+	}
+}
+
+func (db *DB) stopHealthCheckLocked() {
+	db.mu.Lock()
+	stop := db.stopHealthCheck
+	db.stopHealthCheck = nil
+	db.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		db.healthCheckWG.Wait()
+	}
+}
+
+// pickSlave resolves the configured Balancer against db's slaves, returning
+// the chosen physical db together with its index into db.pdbs. It returns
+// the master if there are no slaves.
+func (db *DB) pickSlave() (int, *sql.DB) {
+	slaves := db.pdbs[1:]
+	if len(slaves) == 0 {
+		return 0, db.Master()
 	}
-	return int(1 + (atomic.AddUint64(&db.count, 1) % uint64(n-1)))
+
+	db.mu.RLock()
+	healthy := db.healthy
+	db.mu.RUnlock()
+
+	idx := db.balancer.Pick(slaves, healthy)
+	if idx < 0 || idx >= len(slaves) {
+		idx = 0
+	}
+
+	return idx + 1, slaves[idx]
+}
+
+// pickRead resolves r against db's physical databases, honoring an explicit
+// UseMaster or UseReplica hint over the Balancer's pick.
+func (db *DB) pickRead(r routing) (int, *sql.DB) {
+	if idx, ok := r.resolve(len(db.pdbs)); ok {
+		return idx, db.pdbs[idx]
+	}
+
+	return db.pickSlave()
+}
+
+// reportLatency feeds d back to the configured Balancer if it implements
+// LatencyReporter, such as P2CLatencyBalancer. idx is the slave's index
+// into db.pdbs; reads served by the master aren't reported.
+func (db *DB) reportLatency(idx int, d time.Duration) {
+	if idx == 0 {
+		return
+	}
+	if lr, ok := db.balancer.(LatencyReporter); ok {
+		lr.Report(idx-1, d)
+	}
+}
+
+// route resolves r against db's physical databases, honoring an explicit
+// UseMaster or UseReplica hint over fallback, which is db.Master for writes.
+func (db *DB) route(r routing, fallback func() *sql.DB) *sql.DB {
+	if idx, ok := r.resolve(len(db.pdbs)); ok {
+		return db.pdbs[idx]
+	}
+
+	return fallback()
 }
@@ -1,14 +1,33 @@
 package nap
 
 import (
+	"context"
 	"database/sql"
-	"strings"
+	"database/sql/driver"
+	"sync/atomic"
 	"testing"
-	"testing/quick"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// dsnConnector adapts a registered driver.Driver and a DSN into a
+// driver.Connector, the way database/sql's own OpenDB doc recommends for
+// drivers (such as go-sqlite3) that don't implement driver.DriverContext
+// themselves.
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+func (t dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return t.drv.Open(t.dsn)
+}
+
+func (t dsnConnector) Driver() driver.Driver {
+	return t.drv
+}
+
 func TestOpen(t *testing.T) {
 	// https://www.sqlite.org/inmemorydb.html
 	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
@@ -42,23 +61,37 @@ func TestClose(t *testing.T) {
 }
 
 func TestSlave(t *testing.T) {
-	db := &DB{}
-	last := -1
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
 
-	err := quick.Check(func(n int) bool {
-		index := db.slave(n)
-		if n <= 1 {
-			return index == 0
+	last := -1
+	for i := 0; i < 100; i++ {
+		idx, pdb := db.pickSlave()
+		if idx <= 0 || idx >= len(db.pdbs) {
+			t.Fatalf("slave index out of range: %d", idx)
 		}
+		if pdb != db.pdbs[idx] {
+			t.Fatalf("pickSlave returned a physical db inconsistent with its index")
+		}
+		if idx == last {
+			t.Errorf("RoundRobinBalancer picked the same slave twice in a row: %d", idx)
+		}
+		last = idx
+	}
+}
 
-		result := index > 0 && index < n && index != last
-		last = index
-
-		return result
-	}, nil)
-
+func TestSlaveNoSlaves(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if idx, pdb := db.pickSlave(); idx != 0 || pdb != db.Master() {
+		t.Errorf("Expected pickSlave to fall back to the master. Got index: %d", idx)
 	}
 }
 
@@ -74,30 +107,184 @@ func TestQueryRow(t *testing.T) {
 		t.Error(err)
 	}
 
-	master := false
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "create table t1 (c1 int, c2 int)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "insert into t1(c1,c2) values(1,1)"); err != nil {
+		t.Fatal(err)
+	}
 
-	db.SetQueryRowDB(func(query string, args ...interface{}) *sql.DB {
-		if len(query) > 12 && strings.ToLower(query)[0:12] == "insert into " {
-			master = true
-			return db.Master()
+	if row := db.QueryRow("select * from t1"); row == nil {
+		t.Errorf("func QueryRow has no results")
+	}
+
+	// t1 only exists on the master's in-memory sqlite db, so a QueryRow
+	// routed there with UseMaster must find the row.
+	row := db.QueryRowContext(ctx, "select * from t1", UseMaster())
+	if row == nil {
+		t.Errorf("func QueryRowContext has no results")
+	}
+	if err := row.Scan(new(int), new(int)); err != nil {
+		t.Errorf("expected QueryRowContext with UseMaster to see t1. Got: %s", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(5)
+
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := db.Stats()
+	if want, got := len(db.pdbs), len(stats); want != got {
+		t.Fatalf("Unexpected number of DBStats. Got: %d, Want: %d", got, want)
+	}
+
+	var wantOpen int
+	for _, s := range stats {
+		wantOpen += s.OpenConnections
+		if s.MaxOpenConnections != 5 {
+			t.Fatalf("expected MaxOpenConnections 5 on every physical db, got %d", s.MaxOpenConnections)
+		}
+	}
+
+	agg := db.AggregateStats()
+	if agg.OpenConnections != wantOpen {
+		t.Errorf("AggregateStats.OpenConnections = %d, want sum %d", agg.OpenConnections, wantOpen)
+	}
+	if agg.MaxOpenConnections != 5 {
+		t.Errorf("AggregateStats.MaxOpenConnections = %d, want max 5", agg.MaxOpenConnections)
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	tmp, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	drv := tmp.Driver()
+
+	db := OpenDB(dsnConnector{dsn: ":memory:", drv: drv}, dsnConnector{dsn: ":memory:", drv: drv})
+	defer db.Close()
+
+	if want, got := 2, len(db.pdbs); want != got {
+		t.Errorf("Unexpected number of physical dbs. Got: %d, Want: %d", got, want)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOpenDBs(t *testing.T) {
+	master, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := OpenDBs([]*sql.DB{master, slave})
+	defer db.Close()
+
+	if db.Master() != master {
+		t.Error("expected OpenDBs to use dbs[0] as the master")
+	}
+	if want, got := 2, len(db.pdbs); want != got {
+		t.Errorf("Unexpected number of physical dbs. Got: %d, Want: %d", got, want)
+	}
+}
+
+func TestOpenDBsPanicsOnEmpty(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected OpenDBs(nil) to panic rather than produce a *DB with no master")
+		}
+	}()
+	OpenDBs(nil)
+}
+
+func TestHealthCheckExcludesDeadSlave(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Kill the second slave (db.pdbs[2], slave index 1) so PingContext
+	// against it fails deterministically, without relying on the network.
+	if err := db.pdbs[2].Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var hookCalls int32
+	unhealthy := make(chan int, 1)
+	db.HealthCheckHook(func(idx int, err error) {
+		atomic.AddInt32(&hookCalls, 1)
+		if err != nil {
+			select {
+			case unhealthy <- idx:
+			default:
+			}
 		}
-		master = false
-		return db.Slave()
 	})
 
-	res := db.QueryRow("insert into t1(c1,c2) values(1,1);", nil)
-	if res == nil {
-		t.Errorf("func QueryRow has no results")
+	db.SetHealthCheck(5*time.Millisecond, 50*time.Millisecond)
+
+	select {
+	case idx := <-unhealthy:
+		if idx != 1 {
+			t.Fatalf("expected slave index 1 to be reported unhealthy, got %d", idx)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for HealthCheckHook to report the dead slave")
 	}
-	if !master {
-		t.Errorf("query row expected to use master database")
+
+	if atomic.LoadInt32(&hookCalls) == 0 {
+		t.Fatal("expected HealthCheckHook to be called")
 	}
 
-	res = db.QueryRow("select * from t1", nil)
-	if res == nil {
-		t.Errorf("func QueryRow has no results")
+	// checkHealth marks a slave unhealthy before calling the hook for it, so
+	// from here on pickSlave must never return the dead slave.
+	for i := 0; i < 100; i++ {
+		if _, pdb := db.pickSlave(); pdb == db.pdbs[2] {
+			t.Fatal("pickSlave returned the unhealthy slave")
+		}
 	}
-	if master {
-		t.Errorf("query row expected to use slave database")
+}
+
+func TestCloseStopsHealthCheckGoroutine(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.SetHealthCheck(5*time.Millisecond, 50*time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- db.Close() }()
+
+	// Close blocks on healthCheckWG.Wait() until runHealthCheck's goroutine
+	// has returned, so Close returning promptly proves it exited cleanly
+	// rather than leaking.
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("DB.Close did not return; health check goroutine likely leaked")
 	}
 }
@@ -0,0 +1,83 @@
+package nap
+
+import (
+	"database/sql"
+)
+
+// Routing hints are carried as sql.NamedArg values under these reserved
+// names. extractHints strips them from the argument list before it reaches
+// the driver, so the driver never sees them.
+const (
+	hintUseMaster  = "nap_use_master"
+	hintUseReplica = "nap_use_replica"
+)
+
+// UseMaster returns a routing hint that forces the call to be executed
+// against the master, regardless of its default routing. Pass it like any
+// other argument, e.g. db.QueryContext(ctx, query, nap.UseMaster()).
+func UseMaster() sql.NamedArg {
+	return sql.Named(hintUseMaster, true)
+}
+
+// UseReplica returns a routing hint that forces the call to be executed
+// against the slave at idx, its 0-based position among the slaves passed to
+// Open/OpenDB/OpenDBs; nap has no replica naming registry, so it's an index
+// rather than a name. An idx with no matching slave falls back to the
+// default routing rather than returning an error, since the hint is
+// extracted long before there's anywhere to report one to.
+func UseReplica(idx int) sql.NamedArg {
+	return sql.Named(hintUseReplica, idx)
+}
+
+// routing is the set of hints extracted from a call's argument list.
+type routing struct {
+	useMaster  bool
+	useReplica int
+	hasReplica bool
+}
+
+// resolve reports the index into a set of n physical databases (or a Stmt's
+// parallel slice of prepared statements) implied by an explicit UseMaster or
+// UseReplica hint, with ok false when neither hint applies or the hinted
+// replica is out of range, so the caller can fall back to its own default
+// (e.g. the configured Balancer). db.pickRead, db.route and stmt.route all
+// share this so their hint-resolution logic can't drift apart.
+func (r routing) resolve(n int) (idx int, ok bool) {
+	if r.useMaster {
+		return 0, true
+	}
+	if r.hasReplica && r.useReplica+1 < n {
+		return r.useReplica + 1, true
+	}
+	return 0, false
+}
+
+// extractHints removes nap routing hints from args, returning the remaining
+// driver-bound arguments and the routing they described.
+func extractHints(args []interface{}) ([]interface{}, routing) {
+	var r routing
+
+	out := args
+	for i := 0; i < len(out); i++ {
+		named, ok := out[i].(sql.NamedArg)
+		if !ok {
+			continue
+		}
+
+		switch named.Name {
+		case hintUseMaster:
+			r.useMaster, _ = named.Value.(bool)
+		case hintUseReplica:
+			if idx, ok := named.Value.(int); ok && idx >= 0 {
+				r.useReplica, r.hasReplica = idx, true
+			}
+		default:
+			continue
+		}
+
+		out = append(out[:i], out[i+1:]...)
+		i--
+	}
+
+	return out, r
+}
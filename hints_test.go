@@ -0,0 +1,34 @@
+package nap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUseReplica(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "create table t1 (c1 int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// t1 only exists on the master's in-memory sqlite db; UseReplica(0)
+	// must route to the first slave, which doesn't have it.
+	if _, err := db.QueryContext(ctx, "select * from t1", UseReplica(0)); err == nil {
+		t.Error("expected UseReplica(0) to route to a slave lacking t1")
+	}
+
+	// An out-of-range replica index has nowhere to report an error to, so it
+	// must fall back to the default slave routing instead of erroring or
+	// panicking.
+	rows, err := db.QueryContext(ctx, "select 1", UseReplica(99))
+	if err != nil {
+		t.Fatalf("expected an out-of-range UseReplica to fall back to default routing, got: %s", err)
+	}
+	rows.Close()
+}
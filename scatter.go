@@ -0,0 +1,29 @@
+package nap
+
+import "sync"
+
+// scatter runs fn(i) concurrently for i in [0,n), waiting for all of them
+// to finish, and returns the first non-nil error encountered, if any.
+func scatter(n int, fn func(i int) error) error {
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
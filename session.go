@@ -0,0 +1,42 @@
+package nap
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type sessionKey struct{}
+
+// session tracks the time of the last write performed through a DB within a
+// logical unit of work, so that subsequent reads made with the same
+// context can be routed to the master until replicas have likely caught
+// up. See Session and DB.SetReadYourWritesWindow.
+type session struct {
+	lastWrite atomic.Value // time.Time
+}
+
+// Session returns a copy of ctx carrying a new nap session. Wrap it around
+// the start of a request handler (or any other logical unit of work) and
+// thread the returned context through to every DB call made within it, so
+// that DB.SetReadYourWritesWindow forces master reads only for writes made
+// within that same unit of work, rather than for the lifetime of the
+// process. Calls made with a context that was never passed through Session
+// are never subject to the read-your-writes window.
+func Session(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+func sessionFrom(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+func (s *session) recordWrite() {
+	s.lastWrite.Store(time.Now())
+}
+
+func (s *session) recentWrite(window time.Duration) bool {
+	t, ok := s.lastWrite.Load().(time.Time)
+	return ok && time.Since(t) < window
+}
@@ -0,0 +1,35 @@
+package nap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadYourWritesWindow(t *testing.T) {
+	db, err := Open("sqlite3", ":memory:;:memory:;:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetReadYourWritesWindow(time.Minute)
+
+	ctx := Session(context.Background())
+	if _, err := db.ExecContext(ctx, "create table t1 (c1 int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// t1 only exists on the master's in-memory sqlite db; a read routed to
+	// a slave would fail with "no such table".
+	rows, err := db.QueryContext(ctx, "select * from t1")
+	if err != nil {
+		t.Errorf("Expected read within the window to be routed to the master. Got: %s", err)
+	} else {
+		rows.Close()
+	}
+
+	if _, err := db.QueryContext(context.Background(), "select * from t1"); err == nil {
+		t.Error("Expected a read without a Session context to be routed to a slave and fail")
+	}
+}
@@ -1,8 +1,9 @@
 package nap
 
 import (
+	"context"
 	"database/sql"
-    "time"
+	"time"
 )
 
 // Stmt is an aggregate prepared statement.
@@ -10,16 +11,16 @@ import (
 type Stmt interface {
 	Close() error
 	Exec(...interface{}) (sql.Result, error)
+	ExecContext(context.Context, ...interface{}) (sql.Result, error)
 	Query(...interface{}) (*sql.Rows, error)
+	QueryContext(context.Context, ...interface{}) (*sql.Rows, error)
 	QueryRow(...interface{}) *sql.Row
+	QueryRowContext(context.Context, ...interface{}) *sql.Row
 }
 
-
-
 type stmt struct {
 	db    *DB
 	stmts []*sql.Stmt
-    timeout time.Duration
 }
 
 // Close closes the statement by concurrently closing all underlying
@@ -37,20 +38,53 @@ func (s *stmt) Exec(args ...interface{}) (sql.Result, error) {
 	return s.stmts[0].Exec(args...)
 }
 
+// ExecContext executes a prepared statement with the given context and
+// arguments, returning a Result summarizing the effect of the statement.
+// ExecContext uses the master as the underlying physical db. If ctx
+// carries a nap Session, this Exec is recorded against it so that
+// subsequent reads within the DB.SetReadYourWritesWindow are routed to the
+// master.
+func (s *stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	res, err := s.stmts[0].ExecContext(ctx, args...)
+	if err == nil {
+		s.db.recordWrite(ctx)
+	}
+	return res, err
+}
+
 // Query executes a prepared query statement with the given
-// arguments and returns the query results as a *sql.Rows.
-// Query uses a slave as the underlying physical db.
+// arguments and returns the query results as a *sql.Rows. The args may
+// include a nap routing hint such as UseMaster.
+// Query uses a slave as the underlying physical db by default, and reports
+// its latency back to the configured Balancer just like DB.Query does.
 func (s *stmt) Query(args ...interface{}) (*sql.Rows, error) {
-    if len(args) == 0 {
-        return s.stmts[s.db.slave(len(s.db.pdbs))].Query(args...)
-    }
-    m, ok :=  args[len(args) - 1].(OnlyMaster)
-    if ok && m == true {
-        args = args[0:len(args)-1]
-        return s.stmts[0].Query(args...)   
-    } else {
-	    return s.stmts[s.db.slave(len(s.db.pdbs))].Query(args...)
-    }
+	args, r := extractHints(args)
+	idx, st := s.route(r)
+	start := time.Now()
+	rows, err := st.Query(args...)
+	s.db.reportLatency(idx, time.Since(start))
+	return rows, err
+}
+
+// QueryContext executes a prepared query statement with the given context
+// and arguments, returning the query results as a *sql.Rows. The args may
+// include a nap routing hint such as UseMaster.
+// QueryContext uses a slave as the underlying physical db by default,
+// unless ctx carries a nap Session with a write recorded within the
+// DB.SetReadYourWritesWindow, in which case it dispatches to stmts[0], the
+// statement prepared on the master, since a replica's prepared plan could
+// still return stale rows. Latency is reported back to the configured
+// Balancer just like DB.QueryContext does.
+func (s *stmt) QueryContext(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	args, r := extractHints(args)
+	if s.db.recentWrite(ctx) {
+		r.useMaster = true
+	}
+	idx, st := s.route(r)
+	start := time.Now()
+	rows, err := st.QueryContext(ctx, args...)
+	s.db.reportLatency(idx, time.Since(start))
+	return rows, err
 }
 
 // QueryRow executes a prepared query statement with the given arguments.
@@ -58,16 +92,44 @@ func (s *stmt) Query(args ...interface{}) (*sql.Rows, error) {
 // will be returned by a call to Scan on the returned *Row, which is always non-nil.
 // If the query selects no rows, the *Row's Scan will return ErrNoRows.
 // Otherwise, the *sql.Row's Scan scans the first selected row and discards the rest.
-// QueryRow uses a slave as the underlying physical db.
+// The args may include a nap routing hint such as UseMaster.
+// QueryRow uses a slave as the underlying physical db by default, and
+// reports its latency back to the configured Balancer just like DB.QueryRow
+// does.
 func (s *stmt) QueryRow(args ...interface{}) *sql.Row {
-    if len(args) == 0 {
-        return s.stmts[s.db.slave(len(s.db.pdbs))].QueryRow(args...)
-    }
-    m, ok :=  args[len(args) - 1].(OnlyMaster)
-    if ok && m == true {
-        args = args[0:len(args)-1]
-        return s.stmts[0].QueryRow(args...)
-    } else {
-        return s.stmts[s.db.slave(len(s.db.pdbs))].QueryRow(args...)
-    }
+	args, r := extractHints(args)
+	idx, st := s.route(r)
+	start := time.Now()
+	row := st.QueryRow(args...)
+	s.db.reportLatency(idx, time.Since(start))
+	return row
+}
+
+// QueryRowContext is the context-aware equivalent of QueryRow, subject to
+// the same read-your-writes dispatch and latency reporting described on
+// QueryContext.
+func (s *stmt) QueryRowContext(ctx context.Context, args ...interface{}) *sql.Row {
+	args, r := extractHints(args)
+	if s.db.recentWrite(ctx) {
+		r.useMaster = true
+	}
+	idx, st := s.route(r)
+	start := time.Now()
+	row := st.QueryRowContext(ctx, args...)
+	s.db.reportLatency(idx, time.Since(start))
+	return row
+}
+
+// route resolves r against s's prepared statements, honoring an explicit
+// UseMaster or UseReplica hint, and falling back to the db's configured
+// Balancer otherwise. It returns the chosen statement's index into s.stmts
+// (and db.pdbs) alongside the statement itself, so callers can report
+// latency back to the Balancer.
+func (s *stmt) route(r routing) (int, *sql.Stmt) {
+	if idx, ok := r.resolve(len(s.stmts)); ok {
+		return idx, s.stmts[idx]
+	}
+
+	idx, _ := s.db.pickSlave()
+	return idx, s.stmts[idx]
 }